@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Account is a single WhatsApp device bridged by this process: its own
+// whatsmeow client, its own monitored target, and (via chatKey) its own
+// namespace in the shared chat-history store.
+type Account struct {
+	ID     string
+	Client *whatsmeow.Client
+
+	targetJID types.JID
+	targetMu  sync.RWMutex
+
+	bridgeState accountState
+}
+
+// Target returns the JID this account is currently monitoring, or an
+// empty JID when it's monitoring everything.
+func (a *Account) Target() types.JID {
+	a.targetMu.RLock()
+	defer a.targetMu.RUnlock()
+	return a.targetJID
+}
+
+// SetTarget narrows monitoring to a single phone number.
+func (a *Account) SetTarget(phone string) types.JID {
+	newJID := types.NewJID(phone, types.DefaultUserServer)
+	a.targetMu.Lock()
+	a.targetJID = newJID
+	a.targetMu.Unlock()
+	return newJID
+}
+
+// ClearTarget switches this account back to monitoring all messages.
+func (a *Account) ClearTarget() {
+	a.targetMu.Lock()
+	a.targetJID = types.JID{}
+	a.targetMu.Unlock()
+}
+
+// chatKey namespaces a partner phone number under this account so two
+// accounts bridging the same contact don't share history.
+func (a *Account) chatKey(partnerPhone string) string {
+	return fmt.Sprintf("%s:%s", a.ID, partnerPhone)
+}
+
+// AccountManager maps account IDs to live whatsmeow clients, backed by
+// dbContainer.GetAllDevices, so one process can bridge several WhatsApp
+// numbers concurrently instead of hard-coding a single client.
+type AccountManager struct {
+	mu        sync.RWMutex
+	accounts  map[string]*Account
+	active    string
+	pendingNo int
+}
+
+// NewAccountManager returns an empty manager; call LoadAll to populate it
+// from previously-linked devices.
+func NewAccountManager() *AccountManager {
+	return &AccountManager{accounts: make(map[string]*Account)}
+}
+
+// nextPendingID returns a stable placeholder ID for a device that hasn't
+// paired yet, since device.ID is nil (and JID.String() has a value
+// receiver that panics on a nil *JID) until pairing succeeds.
+func (m *AccountManager) nextPendingID() string {
+	m.pendingNo++
+	return fmt.Sprintf("pending-%d", m.pendingNo)
+}
+
+// LoadAll registers an Account for every device already linked in
+// dbContainer, wiring each to its own event handler.
+func (m *AccountManager) LoadAll(ctx context.Context) error {
+	devices, err := dbContainer.GetAllDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list devices: %w", err)
+	}
+	for _, device := range devices {
+		m.register(device)
+	}
+	return nil
+}
+
+// register wraps device in a whatsmeow client and an Account, routing its
+// events to accountEventHandler. A freshly provisioned device has no JID
+// yet (it's only assigned once pairing succeeds), so such accounts get a
+// placeholder ID until rekey renames them post-pairing.
+func (m *AccountManager) register(device *store.Device) *Account {
+	clientLog := waLog.Stdout("Client", "ERROR", true)
+	c := whatsmeow.NewClient(device, clientLog)
+
+	m.mu.Lock()
+	id := m.nextPendingID()
+	if device.ID != nil {
+		id = device.ID.String()
+	}
+
+	acct := &Account{ID: id, Client: c}
+	m.accounts[acct.ID] = acct
+	if m.active == "" {
+		m.active = acct.ID
+	}
+	m.mu.Unlock()
+
+	acct.setState(StateStarting, "")
+	c.AddEventHandler(func(evt interface{}) { accountEventHandler(acct, evt) })
+
+	return acct
+}
+
+// rekey renames an account from oldID to newID, used once pairing assigns
+// a real JID to a device that was registered under a placeholder ID, and
+// carries its chat history over to the new namespace.
+func (m *AccountManager) rekey(oldID, newID string) {
+	if oldID == newID {
+		return
+	}
+
+	m.mu.Lock()
+	acct, ok := m.accounts[oldID]
+	if ok {
+		delete(m.accounts, oldID)
+		acct.ID = newID
+		m.accounts[newID] = acct
+		if m.active == oldID {
+			m.active = newID
+		}
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := messageStore.RekeyChats(context.Background(), oldID+":", newID+":"); err != nil {
+		fmt.Printf("\n[Error] Failed to rekey history from %s to %s: %v\n> ", oldID, newID, err)
+	}
+}
+
+// Add provisions a brand-new device (no session yet) and registers it as
+// an account; the caller is responsible for driving QR/pairing login
+// against the returned Account's Client.
+func (m *AccountManager) Add() *Account {
+	device := dbContainer.NewDevice()
+	return m.register(device)
+}
+
+// Remove logs an account out and stops bridging it.
+func (m *AccountManager) Remove(ctx context.Context, id string) error {
+	m.mu.Lock()
+	acct, ok := m.accounts[id]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("unknown account %q", id)
+	}
+	delete(m.accounts, id)
+	if m.active == id {
+		m.active = ""
+		for otherID := range m.accounts {
+			m.active = otherID
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	err := acct.Client.Logout(ctx)
+	acct.Client.Disconnect()
+	return err
+}
+
+// List returns the registered account IDs.
+func (m *AccountManager) List() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.accounts))
+	for id := range m.accounts {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Get looks up an account by ID.
+func (m *AccountManager) Get(id string) (*Account, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	acct, ok := m.accounts[id]
+	return acct, ok
+}
+
+// Select switches which account the stdin input loop and provisioning API
+// operate on by default.
+func (m *AccountManager) Select(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.accounts[id]; !ok {
+		return fmt.Errorf("unknown account %q", id)
+	}
+	m.active = id
+	return nil
+}
+
+// Active returns the currently selected account, if any are registered.
+func (m *AccountManager) Active() (*Account, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.active == "" {
+		return nil, false
+	}
+	acct, ok := m.accounts[m.active]
+	return acct, ok
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestAccountChatKey(t *testing.T) {
+	acct := &Account{ID: "acct1"}
+	if got, want := acct.chatKey("1234567890"), "acct1:1234567890"; got != want {
+		t.Errorf("chatKey() = %q, want %q", got, want)
+	}
+}
+
+func TestNextPendingID(t *testing.T) {
+	m := NewAccountManager()
+	first := m.nextPendingID()
+	second := m.nextPendingID()
+	if first == second {
+		t.Errorf("nextPendingID() returned the same ID twice: %q", first)
+	}
+}
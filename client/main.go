@@ -2,25 +2,20 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"mime/multipart"
-	"net/http"
 	"os"
 	"os/signal"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/skip2/go-qrcode"
 	"go.mau.fi/whatsmeow"
-	"go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
@@ -28,13 +23,11 @@ import (
 )
 
 var (
-	targetJID      types.JID
-	targetJIDMutex sync.RWMutex
-	client         *whatsmeow.Client
 	dbContainer    *sqlstore.Container
+	accountManager *AccountManager
 
-	chatHistory    = make(map[string][]string)
-	historyMutex   sync.Mutex
+	messageStore *MessageQuery
+	outbox       *Outbox
 )
 
 func main() {
@@ -45,47 +38,88 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	deviceStore, err := dbContainer.GetFirstDevice(context.Background())
+
+	// historyDB opens its own pool against the same file dbContainer uses
+	// internally; _busy_timeout and WAL mode keep its writers (message
+	// inserts, outbox workers, the history pruner) from hitting "database
+	// is locked" instead of just waiting their turn.
+	historyDB, err := sql.Open("sqlite3", "file:whatsapp_session.db?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		log.Fatalf("Failed to open history database: %v", err)
+	}
+	messageStore, err = NewMessageQuery(historyDB)
 	if err != nil {
-		log.Fatalf("Failed to get device store: %v", err)
+		log.Fatalf("Failed to initialize message store: %v", err)
 	}
+	go startHistoryPruner(messageStore, 24*time.Hour)
 
-	// 2. Initialize Client
-	clientLog := waLog.Stdout("Client", "ERROR", true)
-	client = whatsmeow.NewClient(deviceStore, clientLog)
-	client.AddEventHandler(eventHandler)
+	outbox, err = NewOutbox(historyDB)
+	if err != nil {
+		log.Fatalf("Failed to initialize outbox: %v", err)
+	}
+	outbox.StartOutboxWorkers(context.Background(), 4)
 
-	// 3. Connect & Authenticate
-	if client.Store.ID == nil {
-		qrChan, _ := client.GetQRChannel(context.Background())
-		err = client.Connect()
-		if err != nil {
-			log.Fatalf("Connection failed: %v", err)
-		}
-		for evt := range qrChan {
-			if evt.Event == "code" {
-				fmt.Println("\n--- QR CODE GENERATED ---")
-				err = qrcode.WriteFile(evt.Code, qrcode.Medium, 256, "qr.png")
-				if err != nil {
-					fmt.Printf("Failed to generate QR code file: %v\n", err)
+	// 2. Load every previously-linked device as its own account
+	accountManager = NewAccountManager()
+	if err := accountManager.LoadAll(context.Background()); err != nil {
+		log.Fatalf("Failed to load accounts: %v", err)
+	}
+
+	// 3. Connect & Authenticate. With no linked devices yet, provision one
+	// and walk it through QR or pairing-code login.
+	acct, hasActive := accountManager.Active()
+	if !hasActive {
+		acct = accountManager.Add()
+	}
+
+	if acct.Client.Store.ID == nil {
+		acct.setState(StateConnecting, "")
+		if pairPhone := pairPhoneFlag(); pairPhone != "" {
+			err = loginWithPairCode(acct.Client, pairPhone)
+			if err != nil {
+				log.Fatalf("Pairing failed: %v", err)
+			}
+		} else {
+			qrChan, _ := acct.Client.GetQRChannel(context.Background())
+			err = acct.Client.Connect()
+			if err != nil {
+				log.Fatalf("Connection failed: %v", err)
+			}
+			for evt := range qrChan {
+				if evt.Event == "code" {
+					fmt.Println("\n--- QR CODE GENERATED ---")
+					err = qrcode.WriteFile(evt.Code, qrcode.Medium, 256, "qr.png")
+					if err != nil {
+						fmt.Printf("Failed to generate QR code file: %v\n", err)
+					} else {
+						fmt.Println("QR code saved to 'qr.png'. Please open this file and scan it.")
+					}
+					fmt.Println("QR code string:", evt.Code)
 				} else {
-					fmt.Println("QR code saved to 'qr.png'. Please open this file and scan it.")
+					fmt.Println("Login event:", evt.Event)
 				}
-				fmt.Println("QR code string:", evt.Code)
-			} else {
-				fmt.Println("Login event:", evt.Event)
 			}
 		}
 	} else {
-		err = client.Connect()
+		acct.setState(StateConnecting, "")
+		err = acct.Client.Connect()
 		if err != nil {
 			log.Fatalf("Connection failed: %v", err)
 		}
 	}
 
+	for _, id := range accountManager.List() {
+		if other, ok := accountManager.Get(id); ok && other != acct && other.Client.Store.ID != nil {
+			other.setState(StateConnecting, "")
+			if err := other.Client.Connect(); err != nil {
+				fmt.Printf("[Error] Failed to connect account %s: %v\n", id, err)
+			}
+		}
+	}
+
 	fmt.Println("\n✅ Successfully connected to WhatsApp!")
-	if client.Store.ID != nil {
-		fmt.Printf("Logged in as: %s\n", client.Store.ID.User)
+	if acct.Client.Store.ID != nil {
+		fmt.Printf("Logged in as: %s\n", acct.Client.Store.ID.User)
 	} else {
 		fmt.Println("Session active (Waiting for ID sync...)")
 	}
@@ -93,12 +127,24 @@ func main() {
 	// 4. Input Loop for Target Selection
 	go inputLoop()
 
+	// 5. HTTP Provisioning API for frontend-driven session/target management
+	go func() {
+		if err := startProvisioningAPI(provisioningAddr()); err != nil {
+			fmt.Printf("\n[Error] Provisioning API stopped: %v\n> ", err)
+		}
+	}()
+
 	fmt.Println("\n--- MONITORING ACTIVE ---")
 	fmt.Println("Commands:")
-	fmt.Println("  'set <phone>' - Change the number to monitor (e.g., set 919876543210)")
-	fmt.Println("  'all'        - Monitor all incoming messages")
-	fmt.Println("  'exit'       - Close the application")
+	fmt.Println("  'set <phone>'  - Change the number the active account monitors (e.g., set 919876543210)")
+	fmt.Println("  'all'         - Monitor all incoming messages on the active account")
+	fmt.Println("  'add'         - Provision a new account (scan its QR or 'pair <phone>' once selected)")
+	fmt.Println("  'remove <id>' - Log out and stop bridging an account")
+	fmt.Println("  'list'        - List accounts, '*' marks the active one")
+	fmt.Println("  'select <id>' - Make an account active for 'set'/'all'/'pair'")
+	fmt.Println("  'exit'        - Close the application")
 	fmt.Println("--------------------------")
+	fmt.Println("Tip: run with 'pair <phone>' before a session exists to log in via pairing code instead of QR.")
 
 	// Wait for interrupt
 	c := make(chan os.Signal, 1)
@@ -106,7 +152,41 @@ func main() {
 	<-c
 
 	fmt.Println("\nShutting down...")
-	client.Disconnect()
+	for _, id := range accountManager.List() {
+		if acct, ok := accountManager.Get(id); ok {
+			acct.Client.Disconnect()
+		}
+	}
+}
+
+// pairPhoneFlag inspects os.Args for a "pair <phone>" invocation
+// (e.g. `./client pair 919876543210`), returning the phone number
+// to pair with or "" if no pairing was requested.
+func pairPhoneFlag() string {
+	for i, arg := range os.Args {
+		if arg == "pair" && i+1 < len(os.Args) {
+			return strings.TrimSpace(os.Args[i+1])
+		}
+	}
+	return ""
+}
+
+// loginWithPairCode connects the client and requests an 8-letter pairing
+// code for phone instead of a QR code, which is more usable on headless
+// deployments where scanning a QR image isn't practical.
+func loginWithPairCode(client *whatsmeow.Client, phone string) error {
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("connection failed: %w", err)
+	}
+
+	code, err := client.PairPhone(context.Background(), phone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+	if err != nil {
+		return fmt.Errorf("pair phone failed: %w", err)
+	}
+
+	fmt.Println("\n--- PAIRING CODE GENERATED ---")
+	fmt.Printf("Enter this code on your phone (Linked Devices > Link with phone number): %s\n", code)
+	return nil
 }
 
 func inputLoop() {
@@ -117,41 +197,89 @@ func inputLoop() {
 			break
 		}
 		input := strings.TrimSpace(scanner.Text())
-		
-		if input == "exit" {
+
+		acct, hasActive := accountManager.Active()
+
+		switch {
+		case input == "exit":
 			os.Exit(0)
-		} else if input == "all" {
-			targetJIDMutex.Lock()
-			targetJID = types.JID{}
-			targetJIDMutex.Unlock()
+		case input == "all":
+			if !hasActive {
+				fmt.Println("Error: no active account. Use 'add' first.")
+				continue
+			}
+			acct.ClearTarget()
 			fmt.Println("Now monitoring ALL messages.")
-		} else if strings.HasPrefix(input, "set ") {
+		case strings.HasPrefix(input, "set "):
+			if !hasActive {
+				fmt.Println("Error: no active account. Use 'add' first.")
+				continue
+			}
 			phone := strings.TrimSpace(strings.TrimPrefix(input, "set "))
 			if phone == "" {
 				fmt.Println("Error: Please provide a phone number.")
 				continue
 			}
-			newJID := types.NewJID(phone, types.DefaultUserServer)
-			targetJIDMutex.Lock()
-			targetJID = newJID
-			targetJIDMutex.Unlock()
+			newJID := acct.SetTarget(phone)
 			fmt.Printf("Target updated! Now monitoring: %s\n", newJID.String())
-		} else {
-			fmt.Println("Unknown command. Use 'set <phone>', 'all', or 'exit'.")
+		case input == "list":
+			for _, id := range accountManager.List() {
+				marker := " "
+				if hasActive && id == acct.ID {
+					marker = "*"
+				}
+				fmt.Printf("%s %s\n", marker, id)
+			}
+		case input == "add":
+			newAcct := accountManager.Add()
+			fmt.Printf("Account %s created. It has no session yet; scan a QR or use 'pair <phone>' for it once selected.\n", newAcct.ID)
+		case strings.HasPrefix(input, "pair "):
+			if !hasActive {
+				fmt.Println("Error: no active account. Use 'add' first.")
+				continue
+			}
+			phone := strings.TrimSpace(strings.TrimPrefix(input, "pair "))
+			if phone == "" {
+				fmt.Println("Error: Please provide a phone number.")
+				continue
+			}
+			if acct.Client.Store.ID != nil {
+				fmt.Println("Error: account is already paired.")
+				continue
+			}
+			if err := loginWithPairCode(acct.Client, phone); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		case strings.HasPrefix(input, "remove "):
+			id := strings.TrimSpace(strings.TrimPrefix(input, "remove "))
+			if err := accountManager.Remove(context.Background(), id); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			fmt.Printf("Account %s removed.\n", id)
+		case strings.HasPrefix(input, "select "):
+			id := strings.TrimSpace(strings.TrimPrefix(input, "select "))
+			if err := accountManager.Select(id); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			fmt.Printf("Active account is now %s\n", id)
+		default:
+			fmt.Println("Unknown command. Use 'set <phone>', 'all', 'add', 'pair <phone>', 'remove <id>', 'list', 'select <id>', or 'exit'.")
 		}
 	}
 }
 
-func eventHandler(evt interface{}) {
+// accountEventHandler processes an incoming whatsmeow event for a single
+// account, routing it through the same message pipeline regardless of
+// which linked device it came from.
+func accountEventHandler(acct *Account, evt interface{}) {
 	switch v := evt.(type) {
 	case *events.Message:
 		sender := v.Info.Sender
-		
-		targetJIDMutex.RLock()
-		tJID := targetJID
-		targetJIDMutex.RUnlock()
+		tJID := acct.Target()
 
-		// Logic: 
+		// Logic:
 		// 1. If we are monitoring ALL messages (tJID is empty), show EVERYTHING (including mine).
 		// 2. If we are monitoring a SPECIFIC person, show only messages from them (ignore mine).
 		isTarget := false
@@ -162,13 +290,14 @@ func eventHandler(evt interface{}) {
 		}
 
 		if isTarget {
-			messageText := extractText(v.Message)
-			if messageText != "" {
+			extracted := extractMessage(context.Background(), acct.Client, v.Info.ID, v.Message)
+			if extracted.Type != "" {
+				messageText := displayText(extracted)
 				timestamp := time.Now().Format("15:04:05")
-				
+
 				// 1. Determine Identity Details
 				displayName := sender.User // Fallback
-				contact, _ := client.Store.Contacts.GetContact(context.Background(), sender)
+				contact, _ := acct.Client.Store.Contacts.GetContact(context.Background(), sender)
 				if contact.FullName != "" {
 					displayName = contact.FullName
 				} else if v.Info.PushName != "" {
@@ -179,113 +308,166 @@ func eventHandler(evt interface{}) {
 					if jid.Server == types.DefaultUserServer {
 						return jid.User
 					}
-					
+
 					// If it's a LID, try to find the PN mapping in the store
 					if jid.Server == "lid" || jid.Server == types.HiddenUserServer {
-						pnJID, err := client.Store.LIDs.GetPNForLID(context.Background(), jid)
+						pnJID, err := acct.Client.Store.LIDs.GetPNForLID(context.Background(), jid)
 						if err == nil && !pnJID.IsEmpty() {
 							return pnJID.User
 						}
 					}
-					
+
 					return jid.User // Still LID if no mapping found
 				}
 
 				senderPhone := resolvePhone(sender)
-				receiverPhone := resolvePhone(client.Store.GetJID())
+				receiverPhone := resolvePhone(acct.Client.Store.GetJID())
 
 				// Identify the conversation "Partner" (the other person in the chat)
 				partnerPhone := senderPhone
 				actualSenderName := displayName
 				if v.Info.IsFromMe {
-					senderPhone = resolvePhone(client.Store.GetJID())
+					senderPhone = resolvePhone(acct.Client.Store.GetJID())
 					receiverPhone = resolvePhone(v.Info.Chat)
 					partnerPhone = receiverPhone
 					actualSenderName = "Me"
-					if client.Store.PushName != "" {
-						actualSenderName = client.Store.PushName
+					if acct.Client.Store.PushName != "" {
+						actualSenderName = acct.Client.Store.PushName
 					}
 				}
-				
+
 				// 3. Format Output
-				logEntry := fmt.Sprintf("[%s] %s (%s): %s", timestamp, actualSenderName, senderPhone, messageText)
-				output := fmt.Sprintf("%s - %s - %s - %s to %s : %s", 
-					timestamp, 
-					displayName, 
-					sender.String(), 
-					senderPhone, 
-					receiverPhone, 
+				output := fmt.Sprintf("%s - %s - %s - %s to %s : %s",
+					timestamp,
+					displayName,
+					sender.String(),
+					senderPhone,
+					receiverPhone,
 					messageText)
-				
-				fmt.Printf("\r%s\n> ", output)
 
-				// 4. Store in Chat History
-				historyMutex.Lock()
-				chatHistory[partnerPhone] = append(chatHistory[partnerPhone], logEntry)
-				currentHistory := make([]string, len(chatHistory[partnerPhone]))
-				copy(currentHistory, chatHistory[partnerPhone])
-				historyMutex.Unlock()
+				fmt.Printf("\r[%s] %s\n> ", acct.ID, output)
+
+				// 4. Persist to chat history, namespaced per account
+				ctx := context.Background()
+				chatKey := acct.chatKey(partnerPhone)
+				err := messageStore.Insert(ctx, &Message{
+					ChatJID:      chatKey,
+					ChatReceiver: receiverPhone,
+					JID:          v.Info.ID,
+					Sender:       actualSenderName,
+					Timestamp:    v.Info.Timestamp,
+					Sent:         v.Info.IsFromMe,
+					Text:         messageText,
+				})
+				if err != nil {
+					fmt.Printf("\n[Error] Failed to persist message: %v\n> ", err)
+				}
+				currentHistory := formatHistory(ctx, chatKey)
 
 				// Send context to backend
-				go sendToBackend(senderPhone, messageText, currentHistory)
+				go sendToBackend(senderPhone, extracted, currentHistory)
 
 				// 5. Triggers: Placed or Cancelled
 				if v.Info.IsFromMe {
 					if strings.Contains(messageText, "Your order has been placed!") {
 						// Send history to invoice service directly
 						go sendHistoryToInvoice(partnerPhone, displayName, currentHistory)
-						
+
 						fmt.Printf("\n[System] Order placed. Requesting PDF from makeaton for %s (%s)...\n", displayName, partnerPhone)
 					} else if strings.Contains(messageText, "Order has been cancelled!") {
 						// Silently clear history
-						historyMutex.Lock()
-						delete(chatHistory, partnerPhone)
-						historyMutex.Unlock()
+						if err := messageStore.DeleteChat(ctx, chatKey); err != nil {
+							fmt.Printf("\n[Error] Failed to clear history for %s: %v\n> ", partnerPhone, err)
+						}
 						fmt.Printf("\n[System] Chat history cleared for %s\n> ", partnerPhone)
 					}
 				}
 			}
 		}
+
+	case *events.PairSuccess:
+		accountManager.rekey(acct.ID, v.ID.String())
+
+	case *events.Connected:
+		acct.setState(StateConnected, "")
+
+	case *events.Disconnected:
+		acct.setState(StateTransientDisconnect, "disconnected, will reconnect")
+
+	case *events.StreamReplaced:
+		acct.setState(StateTransientDisconnect, "stream replaced by another connection")
+
+	case *events.LoggedOut:
+		if v.Reason == events.ConnectFailureLoggedOut || v.Reason == events.ConnectFailureNotFound {
+			acct.setState(StateBadCredentials, v.Reason.String())
+		} else {
+			acct.setState(StateLoggedOut, v.Reason.String())
+		}
+
+	case *events.TemporaryBan:
+		acct.setState(StateTransientDisconnect, fmt.Sprintf("temporarily banned: %s", v.Code.String()))
+
+	case *events.ClientOutdated:
+		acct.setState(StateBadCredentials, "client version is outdated")
 	}
 }
 
-func extractText(msg *waE2E.Message) string {
-	if msg == nil {
-		return ""
+// displayText renders an ExtractedMessage as a single line for the
+// console log and chat-history entries, falling back to the media type
+// (and caption, if any) when there's no plain text to show.
+func displayText(msg ExtractedMessage) string {
+	if msg.Text != "" {
+		return msg.Text
 	}
-	if msg.Conversation != nil {
-		return msg.GetConversation()
+	if msg.Caption != "" {
+		return fmt.Sprintf("[%s] %s", msg.Type, msg.Caption)
 	}
-	if msg.ExtendedTextMessage != nil {
-		return msg.ExtendedTextMessage.GetText()
+	return fmt.Sprintf("[%s]", msg.Type)
+}
+
+// backendURL returns the endpoint sendToBackend posts messages to,
+// defaulting to the makeaton backend so a fresh checkout works without
+// configuration unless BACKEND_URL overrides it.
+func backendURL() string {
+	if url := os.Getenv("BACKEND_URL"); url != "" {
+		return url
 	}
-	return ""
+	return "https://invoice-makeaton-production.up.railway.app/api/messages"
+}
+
+// invoiceURL returns the endpoint sendHistoryToInvoice posts to,
+// overridable via INVOICE_URL.
+func invoiceURL() string {
+	if url := os.Getenv("INVOICE_URL"); url != "" {
+		return url
+	}
+	return "https://invoice-makeaton-production.up.railway.app/api/generate-invoice"
 }
 
-func sendToBackend(sender, message string, history []string) {
-	backendURL := "https://invoice-makeaton-production.up.railway.app/api/messages"
-	
+func sendToBackend(sender string, message ExtractedMessage, history []string) {
 	payload := map[string]interface{}{
-		"sender":  sender,
-		"message": message,
+		"sender": sender,
+		"content": map[string]interface{}{
+			"type":      message.Type,
+			"caption":   message.Caption,
+			"media_url": message.MediaURL,
+			"mimetype":  message.MimeType,
+			"text":      message.Text,
+		},
 		"history": history,
 	}
-	
+
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return
 	}
 
-	resp, err := http.Post(backendURL, "application/json", bytes.NewBuffer(jsonPayload))
-	if err == nil {
-		resp.Body.Close()
+	if err := outbox.Enqueue(context.Background(), backendURL(), jsonPayload); err != nil {
+		fmt.Printf("\n[Error] Failed to queue message for backend: %v\n> ", err)
 	}
 }
 
 func sendHistoryToInvoice(customerPhone string, customerName string, chats []string) {
-	invoiceURL := "https://invoice-makeaton-production.up.railway.app/api/generate-invoice"
-	
-	// Prepare JSON payload
 	requestBody, err := json.Marshal(map[string]interface{}{
 		"chats":          chats,
 		"customer_name":  customerName,
@@ -296,19 +478,11 @@ func sendHistoryToInvoice(customerPhone string, customerName string, chats []str
 		return
 	}
 
-	resp, err := http.Post(invoiceURL, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		fmt.Printf("\n[Error] Failed to connect to makeaton: %v\n> ", err)
+	if err := outbox.Enqueue(context.Background(), invoiceURL(), requestBody); err != nil {
+		fmt.Printf("\n[Error] Failed to queue invoice request: %v\n> ", err)
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		fmt.Printf("\n[System] Invoice request sent to makeaton for %s. Frontend will be notified.\n> ", customerName)
-	} else {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("\n[Error] Makeaton returned status %d: %s\n> ", resp.StatusCode, string(body))
-	}
+	fmt.Printf("\n[System] Invoice request for %s queued for delivery to makeaton.\n> ", customerName)
 }
 
 func saveBillingToFile(customerPhone string, data map[string]interface{}) {
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+// ExtractedMessage is the normalized shape every supported waE2E message
+// type is reduced to before being logged, stored, and forwarded to the
+// invoice backend.
+type ExtractedMessage struct {
+	Type     string // "text", "image", "video", "audio", "document", "sticker", "location", "contact", "reaction"
+	Text     string
+	Caption  string
+	MediaURL string
+	MimeType string
+}
+
+// mediaDir returns the directory downloaded media is persisted to,
+// defaulting to "media" so a fresh checkout works without configuration.
+func mediaDir() string {
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		return dir
+	}
+	return "media"
+}
+
+// extractMessage dispatches on the populated field of msg, downloading
+// media to mediaDir() when present, and returns a structured
+// ExtractedMessage for logging and for the backend payload. Message types
+// not handled below yield an ExtractedMessage with an empty Type.
+func extractMessage(ctx context.Context, c *whatsmeow.Client, msgID string, msg *waE2E.Message) ExtractedMessage {
+	if msg == nil {
+		return ExtractedMessage{}
+	}
+
+	switch {
+	case msg.Conversation != nil:
+		return ExtractedMessage{Type: "text", Text: msg.GetConversation()}
+
+	case msg.ExtendedTextMessage != nil:
+		text := msg.ExtendedTextMessage.GetText()
+		if quoted := msg.ExtendedTextMessage.GetContextInfo().GetQuotedMessage(); quoted != nil {
+			if preview := quotedPreview(quoted); preview != "" {
+				text = fmt.Sprintf("> %s\n%s", preview, text)
+			}
+		}
+		return ExtractedMessage{Type: "text", Text: text}
+
+	case msg.ImageMessage != nil:
+		m := msg.ImageMessage
+		return ExtractedMessage{
+			Type:     "image",
+			Caption:  m.GetCaption(),
+			MimeType: m.GetMimetype(),
+			MediaURL: downloadMedia(ctx, c, msgID, m, m.GetMimetype()),
+		}
+
+	case msg.VideoMessage != nil:
+		m := msg.VideoMessage
+		return ExtractedMessage{
+			Type:     "video",
+			Caption:  m.GetCaption(),
+			MimeType: m.GetMimetype(),
+			MediaURL: downloadMedia(ctx, c, msgID, m, m.GetMimetype()),
+		}
+
+	case msg.AudioMessage != nil:
+		m := msg.AudioMessage
+		return ExtractedMessage{
+			Type:     "audio",
+			MimeType: m.GetMimetype(),
+			MediaURL: downloadMedia(ctx, c, msgID, m, m.GetMimetype()),
+		}
+
+	case msg.DocumentMessage != nil:
+		m := msg.DocumentMessage
+		return ExtractedMessage{
+			Type:     "document",
+			Caption:  m.GetCaption(),
+			MimeType: m.GetMimetype(),
+			MediaURL: downloadMedia(ctx, c, msgID, m, m.GetMimetype()),
+		}
+
+	case msg.StickerMessage != nil:
+		m := msg.StickerMessage
+		return ExtractedMessage{
+			Type:     "sticker",
+			MimeType: m.GetMimetype(),
+			MediaURL: downloadMedia(ctx, c, msgID, m, m.GetMimetype()),
+		}
+
+	case msg.LocationMessage != nil:
+		m := msg.LocationMessage
+		return ExtractedMessage{
+			Type: "location",
+			Text: fmt.Sprintf("%f,%f", m.GetDegreesLatitude(), m.GetDegreesLongitude()),
+		}
+
+	case msg.ContactMessage != nil:
+		m := msg.ContactMessage
+		return ExtractedMessage{Type: "contact", Text: m.GetDisplayName()}
+
+	case msg.ReactionMessage != nil:
+		m := msg.ReactionMessage
+		return ExtractedMessage{Type: "reaction", Text: m.GetText()}
+
+	default:
+		return ExtractedMessage{}
+	}
+}
+
+// quotedPreview summarizes a quoted message for inclusion in a reply's
+// text, without downloading any media it carries: WhatsApp embeds the
+// full quoted message (including a real downloadable ImageMessage/etc.),
+// but we don't know its own message ID here, so downloading it would save
+// a file under the wrong name. Callers that need the quoted media itself
+// should fetch it by its own message ID instead.
+func quotedPreview(msg *waE2E.Message) string {
+	if msg == nil {
+		return ""
+	}
+
+	switch {
+	case msg.Conversation != nil:
+		return msg.GetConversation()
+	case msg.ExtendedTextMessage != nil:
+		return msg.ExtendedTextMessage.GetText()
+	case msg.ImageMessage != nil:
+		return captionOrType("image", msg.ImageMessage.GetCaption())
+	case msg.VideoMessage != nil:
+		return captionOrType("video", msg.VideoMessage.GetCaption())
+	case msg.AudioMessage != nil:
+		return captionOrType("audio", "")
+	case msg.DocumentMessage != nil:
+		return captionOrType("document", msg.DocumentMessage.GetCaption())
+	case msg.StickerMessage != nil:
+		return captionOrType("sticker", "")
+	case msg.LocationMessage != nil:
+		return captionOrType("location", "")
+	case msg.ContactMessage != nil:
+		return msg.ContactMessage.GetDisplayName()
+	default:
+		return ""
+	}
+}
+
+func captionOrType(msgType, caption string) string {
+	if caption != "" {
+		return fmt.Sprintf("[%s] %s", msgType, caption)
+	}
+	return fmt.Sprintf("[%s]", msgType)
+}
+
+// downloadMedia fetches a downloadable message's bytes, writes them to
+// mediaDir() under a name keyed by msgID with the extension matching
+// mimetype, and returns the resulting file path (or "" on failure, which
+// is logged but not fatal so the rest of the event is still processed).
+func downloadMedia(ctx context.Context, c *whatsmeow.Client, msgID string, m whatsmeow.DownloadableMessage, mimetype string) string {
+	data, err := c.Download(ctx, m)
+	if err != nil {
+		fmt.Printf("\n[Error] Failed to download media for message %s: %v\n> ", msgID, err)
+		return ""
+	}
+
+	ext := ".bin"
+	if exts, err := mime.ExtensionsByType(mimetype); err == nil && len(exts) > 0 {
+		ext = exts[0]
+	}
+
+	if err := os.MkdirAll(mediaDir(), 0755); err != nil {
+		fmt.Printf("\n[Error] Failed to create media directory: %v\n> ", err)
+		return ""
+	}
+
+	path := filepath.Join(mediaDir(), msgID+ext)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		fmt.Printf("\n[Error] Failed to save media for message %s: %v\n> ", msgID, err)
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExtractMessageConversation(t *testing.T) {
+	msg := &waE2E.Message{Conversation: proto.String("hi there")}
+	got := extractMessage(nil, nil, "MSG1", msg)
+	if got.Type != "text" || got.Text != "hi there" {
+		t.Errorf("extractMessage(Conversation) = %+v, want text %q", got, "hi there")
+	}
+}
+
+func TestQuotedPreview(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  *waE2E.Message
+		want string
+	}{
+		{"text", &waE2E.Message{Conversation: proto.String("quoted text")}, "quoted text"},
+		{"image with caption", &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Caption: proto.String("a pic")}}, "[image] a pic"},
+		{"image without caption", &waE2E.Message{ImageMessage: &waE2E.ImageMessage{}}, "[image]"},
+		{"nil", nil, ""},
+	}
+	for _, c := range cases {
+		if got := quotedPreview(c.msg); got != c.want {
+			t.Errorf("%s: quotedPreview() = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
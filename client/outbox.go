@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Outbox is a persistent queue of outbound HTTP POSTs, so payloads to the
+// invoice backend survive process restarts and Railway outages instead of
+// being dropped by a fire-and-forget http.Post.
+type Outbox struct {
+	db *sql.DB
+}
+
+const outboxSchema = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	url             TEXT    NOT NULL,
+	payload         TEXT    NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL,
+	last_error      TEXT    NOT NULL DEFAULT ''
+)`
+
+// NewOutbox opens (creating if needed) the outbox table on db.
+func NewOutbox(db *sql.DB) (*Outbox, error) {
+	if _, err := db.Exec(outboxSchema); err != nil {
+		return nil, fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	return &Outbox{db: db}, nil
+}
+
+// Enqueue persists a payload for immediate delivery to url. The actual
+// POST happens asynchronously in the worker pool.
+func (o *Outbox) Enqueue(ctx context.Context, url string, payload []byte) error {
+	_, err := o.db.ExecContext(ctx,
+		`INSERT INTO outbox (url, payload, next_attempt_at) VALUES (?, ?, ?)`,
+		url, string(payload), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	outboxQueued.Add(1)
+	return nil
+}
+
+type outboxRow struct {
+	id       int64
+	url      string
+	payload  string
+	attempts int
+}
+
+// outboxBackoff caps exponential backoff (2^attempts seconds) at one hour.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Duration(1<<uint(attempts)) * time.Second
+	const max = time.Hour
+	if backoff > max || backoff <= 0 {
+		return max
+	}
+	return backoff
+}
+
+// StartOutboxWorkers launches n goroutines that repeatedly pull due rows
+// and attempt delivery, backing off exponentially on failure and only
+// deleting a row once the backend responds 2xx.
+func (o *Outbox) StartOutboxWorkers(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		go o.worker(ctx)
+	}
+}
+
+func (o *Outbox) worker(ctx context.Context) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.processNext(ctx)
+		}
+	}
+}
+
+func (o *Outbox) processNext(ctx context.Context) {
+	r, ok, err := o.claimNext(ctx)
+	if err != nil {
+		fmt.Printf("\n[Error] Failed to claim outbox row: %v\n> ", err)
+		return
+	}
+	if !ok {
+		return // nothing due yet, or another worker already claimed it
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, r.url, bytes.NewBufferString(r.payload))
+	if err != nil {
+		o.fail(ctx, r, err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		o.fail(ctx, r, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		o.db.ExecContext(ctx, `DELETE FROM outbox WHERE id = ?`, r.id)
+		outboxSent.Add(1)
+		return
+	}
+	o.fail(ctx, r, fmt.Sprintf("backend returned status %d", resp.StatusCode))
+}
+
+// claimHold is how long a claimed row is hidden from other workers while
+// its delivery attempt is in flight, comfortably longer than processNext's
+// 15-second request timeout.
+const claimHold = 30 * time.Second
+
+// claimNext atomically claims the next due row by pushing its
+// next_attempt_at into the future and checking RowsAffected, so two
+// workers racing on the same due row can't both claim and double-deliver
+// it; the loser's UPDATE affects zero rows and it tries again next tick.
+func (o *Outbox) claimNext(ctx context.Context) (outboxRow, bool, error) {
+	now := time.Now().Unix()
+
+	var id int64
+	err := o.db.QueryRowContext(ctx, `
+		SELECT id FROM outbox WHERE next_attempt_at <= ? ORDER BY next_attempt_at ASC LIMIT 1`, now).Scan(&id)
+	if err == sql.ErrNoRows {
+		return outboxRow{}, false, nil
+	}
+	if err != nil {
+		return outboxRow{}, false, err
+	}
+
+	res, err := o.db.ExecContext(ctx, `
+		UPDATE outbox SET next_attempt_at = ? WHERE id = ? AND next_attempt_at <= ?`,
+		now+int64(claimHold.Seconds()), id, now)
+	if err != nil {
+		return outboxRow{}, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return outboxRow{}, false, err // n == 0 means another worker claimed it first
+	}
+
+	row := o.db.QueryRowContext(ctx, `
+		SELECT id, url, payload, attempts FROM outbox WHERE id = ?`, id)
+	var r outboxRow
+	if err := row.Scan(&r.id, &r.url, &r.payload, &r.attempts); err != nil {
+		return outboxRow{}, false, err
+	}
+	return r, true, nil
+}
+
+func (o *Outbox) fail(ctx context.Context, r outboxRow, reason string) {
+	attempts := r.attempts + 1
+	next := time.Now().Add(outboxBackoff(attempts)).Unix()
+	o.db.ExecContext(ctx, `
+		UPDATE outbox SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`,
+		attempts, next, reason, r.id)
+	outboxFailed.Add(1)
+}
+
+// Prometheus-style counters surfaced at /metrics.
+var (
+	outboxQueued atomic.Int64
+	outboxSent   atomic.Int64
+	outboxFailed atomic.Int64
+)
+
+func handleOutboxMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "outbox_queued_total %d\n", outboxQueued.Load())
+	fmt.Fprintf(w, "outbox_sent_total %d\n", outboxSent.Load())
+	fmt.Fprintf(w, "outbox_failed_total %d\n", outboxFailed.Load())
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 1 * time.Second},
+		{1, 2 * time.Second},
+		{5, 32 * time.Second},
+		{12, time.Hour}, // 2^12s > 1h, capped
+		{64, time.Hour}, // shift overflow, capped
+	}
+	for _, c := range cases {
+		if got := outboxBackoff(c.attempts); got != c.want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", c.attempts, got, c.want)
+		}
+	}
+}
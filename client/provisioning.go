@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"go.mau.fi/whatsmeow"
+)
+
+// provisioningAddr returns the listen address for the HTTP provisioning
+// API, defaulting to :8080 so a frontend can rely on a stable port unless
+// PROVISIONING_ADDR overrides it.
+func provisioningAddr() string {
+	if addr := os.Getenv("PROVISIONING_ADDR"); addr != "" {
+		return addr
+	}
+	return ":8080"
+}
+
+// provisioningSecret returns the shared secret that the auth middleware
+// checks incoming requests against. An empty secret disables auth, which
+// is only acceptable for local development.
+func provisioningSecret() string {
+	return os.Getenv("PROVISIONING_SECRET")
+}
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// startProvisioningAPI serves the HTTP provisioning API under
+// /api/provisioning/, letting a frontend drive login, session status,
+// logout, and target/history management instead of the stdin inputLoop.
+func startProvisioningAPI(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/provisioning/login", provisioningAuth(handleProvisioningLogin))
+	mux.HandleFunc("/api/provisioning/status", provisioningAuth(handleProvisioningStatus))
+	mux.HandleFunc("/api/provisioning/logout", provisioningAuth(handleProvisioningLogout))
+	mux.HandleFunc("/api/provisioning/target", provisioningAuth(handleProvisioningTarget))
+	mux.HandleFunc("/api/provisioning/chats", provisioningAuth(handleProvisioningChats))
+	mux.HandleFunc("/api/provisioning/chats/", provisioningAuth(handleProvisioningChatHistory))
+	mux.HandleFunc("/api/provisioning/accounts", provisioningAuth(handleProvisioningAccounts))
+	mux.HandleFunc("/api/provisioning/accounts/", provisioningAuth(handleProvisioningAccountAction))
+	mux.HandleFunc("/status", provisioningAuth(handleBridgeStatus))
+	mux.HandleFunc("/metrics", handleOutboxMetrics)
+
+	fmt.Printf("\n[System] Provisioning API listening on %s\n> ", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// provisioningAuth enforces the shared-secret bearer token configured via
+// PROVISIONING_SECRET on every provisioning endpoint.
+func provisioningAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := provisioningSecret()
+		if secret == "" {
+			next(w, r)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if got != secret {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// provisioningLoginEvent is one message sent down the /login websocket,
+// mirroring mautrix-whatsapp's login event shape: a QR code, a pairing
+// code, or a terminal success/error state.
+type provisioningLoginEvent struct {
+	Event string `json:"event"` // "qr", "pair_code", "success", "error", or another QR channel event name passed through as-is
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// resolveAccount picks the account an HTTP request targets: the one named
+// by the ?account= query parameter, or the manager's active account if
+// none was given.
+func resolveAccount(r *http.Request) (*Account, error) {
+	if id := r.URL.Query().Get("account"); id != "" {
+		acct, ok := accountManager.Get(id)
+		if !ok {
+			return nil, fmt.Errorf("unknown account %q", id)
+		}
+		return acct, nil
+	}
+	acct, ok := accountManager.Active()
+	if !ok {
+		return nil, fmt.Errorf("no active account; add one first")
+	}
+	return acct, nil
+}
+
+// handleProvisioningLogin upgrades to a websocket and streams QR codes
+// (default) until the QR channel closes, replacing the ad-hoc stdin/qr.png
+// flow. With ?phone=<number> it sends a single 8-letter pairing code and
+// closes the websocket; the outcome of that pairing is not reported on it.
+func handleProvisioningLogin(w http.ResponseWriter, r *http.Request) {
+	acct, err := resolveAccount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if acct.Client.Store.ID != nil {
+		http.Error(w, "already logged in", http.StatusConflict)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	phone := r.URL.Query().Get("phone")
+	if phone != "" {
+		if err := acct.Client.Connect(); err != nil {
+			conn.WriteJSON(provisioningLoginEvent{Event: "error", Error: err.Error()})
+			return
+		}
+		code, err := acct.Client.PairPhone(r.Context(), phone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+		if err != nil {
+			conn.WriteJSON(provisioningLoginEvent{Event: "error", Error: err.Error()})
+			return
+		}
+		conn.WriteJSON(provisioningLoginEvent{Event: "pair_code", Code: code})
+		return
+	}
+
+	qrChan, err := acct.Client.GetQRChannel(context.Background())
+	if err != nil {
+		conn.WriteJSON(provisioningLoginEvent{Event: "error", Error: err.Error()})
+		return
+	}
+	if err := acct.Client.Connect(); err != nil {
+		conn.WriteJSON(provisioningLoginEvent{Event: "error", Error: err.Error()})
+		return
+	}
+
+	for evt := range qrChan {
+		switch evt.Event {
+		case "code":
+			conn.WriteJSON(provisioningLoginEvent{Event: "qr", Code: evt.Code})
+		case "success":
+			conn.WriteJSON(provisioningLoginEvent{Event: "success"})
+		default:
+			conn.WriteJSON(provisioningLoginEvent{Event: evt.Event})
+		}
+	}
+}
+
+// provisioningStatus is the JSON shape returned by GET /api/provisioning/status.
+type provisioningStatus struct {
+	Account  string `json:"account,omitempty"`
+	LoggedIn bool   `json:"logged_in"`
+	JID      string `json:"jid,omitempty"`
+	PushName string `json:"push_name,omitempty"`
+	Target   string `json:"target"`
+}
+
+func handleProvisioningStatus(w http.ResponseWriter, r *http.Request) {
+	acct, err := resolveAccount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := provisioningStatus{Account: acct.ID}
+	if acct.Client.Store.ID != nil {
+		status.LoggedIn = true
+		status.JID = acct.Client.Store.ID.String()
+		status.PushName = acct.Client.Store.PushName
+	}
+
+	if tJID := acct.Target(); !tJID.IsEmpty() {
+		status.Target = tJID.String()
+	} else {
+		status.Target = "all"
+	}
+
+	writeJSON(w, status)
+}
+
+func handleProvisioningLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	acct, err := resolveAccount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := acct.Client.Logout(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "logged_out"})
+}
+
+// handleProvisioningTarget gets or sets the monitored JID for an account.
+// POST with a {"phone": "..."} body (or an empty phone) sets the target;
+// GET reads it.
+func handleProvisioningTarget(w http.ResponseWriter, r *http.Request) {
+	acct, err := resolveAccount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if tJID := acct.Target(); !tJID.IsEmpty() {
+			writeJSON(w, map[string]string{"target": tJID.String()})
+		} else {
+			writeJSON(w, map[string]string{"target": "all"})
+		}
+	case http.MethodPost:
+		var body struct {
+			Phone string `json:"phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if body.Phone == "" {
+			acct.ClearTarget()
+			writeJSON(w, map[string]string{"target": "all"})
+			return
+		}
+		newJID := acct.SetTarget(body.Phone)
+		writeJSON(w, map[string]string{"target": newJID.String()})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleProvisioningChats lists the phone numbers with stored history for
+// an account.
+func handleProvisioningChats(w http.ResponseWriter, r *http.Request) {
+	acct, err := resolveAccount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chats, err := messageStore.ListChats(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	prefix := acct.ID + ":"
+	phones := make([]string, 0, len(chats))
+	for _, chatJID := range chats {
+		if strings.HasPrefix(chatJID, prefix) {
+			phones = append(phones, strings.TrimPrefix(chatJID, prefix))
+		}
+	}
+	writeJSON(w, map[string][]string{"chats": phones})
+}
+
+// handleProvisioningChatHistory returns the stored history for a single
+// phone number, addressed as /api/provisioning/chats/<phone>.
+func handleProvisioningChatHistory(w http.ResponseWriter, r *http.Request) {
+	acct, err := resolveAccount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	phone := strings.TrimPrefix(r.URL.Path, "/api/provisioning/chats/")
+	if phone == "" {
+		http.Error(w, "phone required", http.StatusBadRequest)
+		return
+	}
+
+	messages, err := messageStore.GetAll(r.Context(), acct.chatKey(phone))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"phone": phone, "history": messages})
+}
+
+// handleProvisioningAccounts lists (GET) or provisions (POST) accounts.
+func handleProvisioningAccounts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string][]string{"accounts": accountManager.List()})
+	case http.MethodPost:
+		acct := accountManager.Add()
+		writeJSON(w, map[string]string{"account": acct.ID})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleProvisioningAccountAction handles /api/provisioning/accounts/<id>/select
+// and .../remove.
+func handleProvisioningAccountAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/provisioning/accounts/")
+	id, action, ok := strings.Cut(rest, "/")
+	if !ok || id == "" {
+		http.Error(w, "expected /accounts/<id>/<select|remove>", http.StatusBadRequest)
+		return
+	}
+
+	switch action {
+	case "select":
+		if err := accountManager.Select(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]string{"active": id})
+	case "remove":
+		if err := accountManager.Remove(r.Context(), id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "removed"})
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// BridgeStateEvent mirrors the bridge-state machine mautrix-whatsapp uses
+// to tell a frontend whether a linked account is usable right now.
+type BridgeStateEvent string
+
+const (
+	StateStarting            BridgeStateEvent = "STARTING"
+	StateConnecting          BridgeStateEvent = "CONNECTING"
+	StateConnected           BridgeStateEvent = "CONNECTED"
+	StateLoggedOut           BridgeStateEvent = "LOGGED_OUT"
+	StateBadCredentials      BridgeStateEvent = "BAD_CREDENTIALS"
+	StateTransientDisconnect BridgeStateEvent = "TRANSIENT_DISCONNECT"
+)
+
+// BridgeState is the JSON shape reported to the /status endpoint and the
+// configurable state-change webhook.
+type BridgeState struct {
+	StateEvent BridgeStateEvent `json:"state_event"`
+	RemoteID   string           `json:"remote_id,omitempty"`
+	RemoteName string           `json:"remote_name,omitempty"`
+	Timestamp  int64            `json:"timestamp"`
+	Reason     string           `json:"reason,omitempty"`
+}
+
+// bridgeStateWebhook returns the URL state changes are POSTed to, or ""
+// if none is configured.
+func bridgeStateWebhook() string {
+	return os.Getenv("BRIDGE_STATE_WEBHOOK_URL")
+}
+
+// accountState tracks the live BridgeState for one account, guarded
+// separately from Account's target mutex since it's updated from the
+// whatsmeow event goroutine rather than the input loop.
+type accountState struct {
+	mu    sync.RWMutex
+	state BridgeState
+}
+
+// setState records a new bridge state for acct, namespaces it with the
+// account's remote identity, and fires it at the configured webhook so a
+// frontend can react to logouts instead of silently missing messages.
+func (acct *Account) setState(event BridgeStateEvent, reason string) {
+	state := BridgeState{
+		StateEvent: event,
+		Timestamp:  time.Now().Unix(),
+		Reason:     reason,
+	}
+	if acct.Client.Store.ID != nil {
+		state.RemoteID = acct.Client.Store.ID.String()
+	}
+	state.RemoteName = acct.Client.Store.PushName
+
+	acct.bridgeState.mu.Lock()
+	acct.bridgeState.state = state
+	acct.bridgeState.mu.Unlock()
+
+	fmt.Printf("\n[System] Account %s is now %s%s\n> ", acct.ID, event, reasonSuffix(reason))
+
+	if url := bridgeStateWebhook(); url != "" {
+		payload, err := json.Marshal(struct {
+			Account string `json:"account"`
+			BridgeState
+		}{Account: acct.ID, BridgeState: state})
+		if err != nil {
+			return
+		}
+		if err := outbox.Enqueue(context.Background(), url, payload); err != nil {
+			fmt.Printf("\n[Error] Failed to queue bridge-state webhook for %s: %v\n> ", acct.ID, err)
+		}
+	}
+}
+
+// State returns the account's last reported bridge state.
+func (acct *Account) State() BridgeState {
+	acct.bridgeState.mu.RLock()
+	defer acct.bridgeState.mu.RUnlock()
+	return acct.bridgeState.state
+}
+
+// handleBridgeStatus serves GET /status?account=<id>, reporting the
+// account's connection state machine in the same shape POSTed to
+// BRIDGE_STATE_WEBHOOK_URL so a frontend can poll or subscribe either way.
+func handleBridgeStatus(w http.ResponseWriter, r *http.Request) {
+	acct, err := resolveAccount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, acct.State())
+}
+
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", reason)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Message is a single row of chat history, persisted so conversations
+// survive restarts and can be paginated instead of living in an
+// unbounded in-memory map.
+type Message struct {
+	ChatJID          string
+	ChatReceiver     string
+	JID              string
+	MXID             string
+	Sender           string
+	Timestamp        time.Time
+	Sent             bool
+	BroadcastListJID string
+	Text             string
+}
+
+// MessageQuery wraps the messages table in whatsapp_session.db, mirroring
+// the query-object pattern mautrix-whatsapp uses for its message store.
+type MessageQuery struct {
+	db *sql.DB
+}
+
+const messagesSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	chat_jid            TEXT    NOT NULL,
+	chat_receiver        TEXT    NOT NULL,
+	jid                  TEXT    NOT NULL,
+	mxid                 TEXT    NOT NULL DEFAULT '',
+	sender               TEXT    NOT NULL,
+	timestamp            INTEGER NOT NULL,
+	sent                 BOOLEAN NOT NULL DEFAULT FALSE,
+	broadcast_list_jid   TEXT    NOT NULL DEFAULT '',
+	text                 TEXT    NOT NULL DEFAULT '',
+	PRIMARY KEY (chat_jid, jid)
+)`
+
+// NewMessageQuery opens (creating if needed) the messages table on db.
+func NewMessageQuery(db *sql.DB) (*MessageQuery, error) {
+	if _, err := db.Exec(messagesSchema); err != nil {
+		return nil, fmt.Errorf("failed to create messages table: %w", err)
+	}
+	return &MessageQuery{db: db}, nil
+}
+
+// Insert adds a message to the given chat, overwriting any prior row with
+// the same (chat_jid, jid) so re-delivered events don't duplicate history.
+func (mq *MessageQuery) Insert(ctx context.Context, msg *Message) error {
+	_, err := mq.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO messages
+			(chat_jid, chat_receiver, jid, mxid, sender, timestamp, sent, broadcast_list_jid, text)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.ChatJID, msg.ChatReceiver, msg.JID, msg.MXID, msg.Sender,
+		msg.Timestamp.Unix(), msg.Sent, msg.BroadcastListJID, msg.Text)
+	return err
+}
+
+// GetAll returns every message for chatJID, oldest first.
+func (mq *MessageQuery) GetAll(ctx context.Context, chatJID string) ([]*Message, error) {
+	rows, err := mq.db.QueryContext(ctx, `
+		SELECT chat_jid, chat_receiver, jid, mxid, sender, timestamp, sent, broadcast_list_jid, text
+		FROM messages WHERE chat_jid = ? ORDER BY timestamp ASC`, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMessages(rows)
+}
+
+// GetByJID fetches a single message by its WhatsApp message ID within a chat.
+func (mq *MessageQuery) GetByJID(ctx context.Context, chatJID, jid string) (*Message, error) {
+	row := mq.db.QueryRowContext(ctx, `
+		SELECT chat_jid, chat_receiver, jid, mxid, sender, timestamp, sent, broadcast_list_jid, text
+		FROM messages WHERE chat_jid = ? AND jid = ?`, chatJID, jid)
+	msg, err := scanMessage(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return msg, err
+}
+
+// GetLastInChat returns the most recent message in chatJID, or nil if the
+// chat has no history.
+func (mq *MessageQuery) GetLastInChat(ctx context.Context, chatJID string) (*Message, error) {
+	row := mq.db.QueryRowContext(ctx, `
+		SELECT chat_jid, chat_receiver, jid, mxid, sender, timestamp, sent, broadcast_list_jid, text
+		FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT 1`, chatJID)
+	msg, err := scanMessage(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return msg, err
+}
+
+// ListChats returns the distinct chat_jid values with stored history.
+func (mq *MessageQuery) ListChats(ctx context.Context) ([]string, error) {
+	rows, err := mq.db.QueryContext(ctx, `SELECT DISTINCT chat_jid FROM messages`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []string
+	for rows.Next() {
+		var chatJID string
+		if err := rows.Scan(&chatJID); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chatJID)
+	}
+	return chats, rows.Err()
+}
+
+// DeleteChat removes all history for chatJID, used when an order is
+// cancelled and the conversation should start fresh.
+func (mq *MessageQuery) DeleteChat(ctx context.Context, chatJID string) error {
+	_, err := mq.db.ExecContext(ctx, `DELETE FROM messages WHERE chat_jid = ?`, chatJID)
+	return err
+}
+
+// RekeyChats rewrites the chat_jid prefix on every row from oldPrefix to
+// newPrefix, used when an account's placeholder ID (assigned before
+// pairing) is replaced by its real JID so existing history isn't orphaned
+// under the old namespace.
+func (mq *MessageQuery) RekeyChats(ctx context.Context, oldPrefix, newPrefix string) error {
+	_, err := mq.db.ExecContext(ctx, `
+		UPDATE messages SET chat_jid = ? || substr(chat_jid, ?) WHERE chat_jid LIKE ? || '%'`,
+		newPrefix, len(oldPrefix)+1, oldPrefix)
+	return err
+}
+
+// PruneOlderThan deletes messages older than maxAge across all chats and
+// returns how many rows were removed, for the background pruner.
+func (mq *MessageQuery) PruneOlderThan(ctx context.Context, maxAge time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-maxAge).Unix()
+	res, err := mq.db.ExecContext(ctx, `DELETE FROM messages WHERE timestamp < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row rowScanner) (*Message, error) {
+	var msg Message
+	var ts int64
+	err := row.Scan(&msg.ChatJID, &msg.ChatReceiver, &msg.JID, &msg.MXID, &msg.Sender,
+		&ts, &msg.Sent, &msg.BroadcastListJID, &msg.Text)
+	if err != nil {
+		return nil, err
+	}
+	msg.Timestamp = time.Unix(ts, 0)
+	return &msg, nil
+}
+
+func scanMessages(rows *sql.Rows) ([]*Message, error) {
+	var messages []*Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// formatHistory loads a chat's persisted messages and renders them as the
+// flat "[time] sender: text" lines sendToBackend and sendHistoryToInvoice
+// expect, logging and returning nil on failure rather than aborting the
+// caller's event handling.
+func formatHistory(ctx context.Context, chatJID string) []string {
+	messages, err := messageStore.GetAll(ctx, chatJID)
+	if err != nil {
+		fmt.Printf("\n[Error] Failed to load history for %s: %v\n> ", chatJID, err)
+		return nil
+	}
+	lines := make([]string, len(messages))
+	for i, msg := range messages {
+		lines[i] = fmt.Sprintf("[%s] %s: %s", msg.Timestamp.Format("15:04:05"), msg.Sender, msg.Text)
+	}
+	return lines
+}
+
+// historyRetention is how long message history is kept before the
+// background pruner removes it, configurable via HISTORY_RETENTION_DAYS.
+func historyRetention() time.Duration {
+	days := 90
+	if raw := os.Getenv("HISTORY_RETENTION_DAYS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// startHistoryPruner periodically trims rows older than historyRetention()
+// so the messages table doesn't grow unbounded across long-running
+// deployments.
+func startHistoryPruner(mq *MessageQuery, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := mq.PruneOlderThan(context.Background(), historyRetention())
+		if err != nil {
+			fmt.Printf("\n[Error] History pruning failed: %v\n> ", err)
+			continue
+		}
+		if n > 0 {
+			fmt.Printf("\n[System] Pruned %d message(s) older than retention window\n> ", n)
+		}
+	}
+}
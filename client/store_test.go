@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeRow is a minimal rowScanner that copies fixed column values into
+// Scan's destination pointers, so scanMessage can be tested without a
+// real *sql.DB.
+type fakeRow struct {
+	chatJID, chatReceiver, jid, mxid, sender string
+	timestamp                                int64
+	sent                                     bool
+	broadcastListJID, text                   string
+}
+
+func (f fakeRow) Scan(dest ...interface{}) error {
+	*dest[0].(*string) = f.chatJID
+	*dest[1].(*string) = f.chatReceiver
+	*dest[2].(*string) = f.jid
+	*dest[3].(*string) = f.mxid
+	*dest[4].(*string) = f.sender
+	*dest[5].(*int64) = f.timestamp
+	*dest[6].(*bool) = f.sent
+	*dest[7].(*string) = f.broadcastListJID
+	*dest[8].(*string) = f.text
+	return nil
+}
+
+func TestScanMessage(t *testing.T) {
+	row := fakeRow{
+		chatJID: "acct1:1234", jid: "ABCD", sender: "Alice",
+		timestamp: 1700000000, sent: true, text: "hello",
+	}
+	msg, err := scanMessage(row)
+	if err != nil {
+		t.Fatalf("scanMessage returned error: %v", err)
+	}
+	if msg.ChatJID != row.chatJID || msg.JID != row.jid || msg.Sender != row.sender || msg.Text != row.text {
+		t.Errorf("scanMessage = %+v, want fields from %+v", msg, row)
+	}
+	if !msg.Sent {
+		t.Error("scanMessage lost Sent flag")
+	}
+	if !msg.Timestamp.Equal(time.Unix(row.timestamp, 0)) {
+		t.Errorf("scanMessage Timestamp = %v, want %v", msg.Timestamp, time.Unix(row.timestamp, 0))
+	}
+}